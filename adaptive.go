@@ -0,0 +1,327 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdaptiveThrottleOptions - configuration for NewAdaptiveThrottle
+type AdaptiveThrottleOptions struct {
+	// Base - throttle whose Prefix, Storage, Duration and MatchCriteria are
+	// reused; its max count is overridden by the effective max count, and its
+	// Limiter is replaced by a fixed-window Limiter built from that count
+	Base Throttle
+	// Probe - polled every ProbeInterval to get a health signal in [0, 1].
+	// 1 means fully healthy, 0 means fully unhealthy
+	Probe func(context.Context) (float64, error)
+	// ProbeInterval - how often Probe is polled.  Defaults to one minute
+	// when zero
+	ProbeInterval time.Duration
+	// GoodThreshold - health at or above this bumps the effective max by
+	// Step, up to MaxRate
+	GoodThreshold float64
+	// BadThreshold - health at or below this multiplies the effective max
+	// by DecreaseFactor, down to MinRate.  Between BadThreshold and
+	// GoodThreshold, the effective max is held steady
+	BadThreshold float64
+	// Step - additive increase applied on a good probe
+	Step int
+	// DecreaseFactor - multiplicative decrease applied on a bad probe, e.g.
+	// 0.5 to halve the effective max
+	DecreaseFactor float64
+	// MinRate, MaxRate - bounds the effective max is clamped to.  A zero
+	// MaxRate is treated as "no ceiling", not as a ceiling of zero
+	MinRate int
+	MaxRate int
+	// InitialRate - effective max to start at.  Defaults to Base's own
+	// GetMaxCount() when zero
+	InitialRate int
+	// Logger - defaults to DefaultLogger when nil
+	Logger Logger
+	// Metrics - defaults to Base's Metrics when nil
+	Metrics Metrics
+}
+
+// AdaptiveThrottle - a Throttle that wraps a Base throttle and rescales its
+// effective max count up or down in response to an external health signal,
+// AIMD-style: additive increase while Probe reports health, multiplicative
+// decrease once it doesn't.  Modelled on the replication-lag-driven
+// throttling used by some database proxies to shed load before a struggling
+// replica falls further behind
+//
+// Exported as a concrete type, rather than returned as a Throttle, so
+// callers can reach GetEffectiveMaxCount/Stats/Stop directly; it still
+// satisfies the Throttle interface for use in a throttle chain
+type AdaptiveThrottle struct {
+	base    Throttle
+	options AdaptiveThrottleOptions
+
+	mu          sync.RWMutex
+	rate        int
+	lastHealth  float64
+	lastProbeAt time.Time
+
+	cancel context.CancelFunc
+}
+
+// NewAdaptiveThrottle - patchable entry point for getting a new
+// AdaptiveThrottle.  Starts the probe loop immediately; call Stop when done
+var NewAdaptiveThrottle = newAdaptiveThrottle
+
+// newAdaptiveThrottle - create a new AdaptiveThrottle and start its probe
+// loop.  The probe loop is only started when options.Probe is set; without
+// one there is no health signal to react to, so the effective rate simply
+// stays pinned at its initial value
+func newAdaptiveThrottle(options AdaptiveThrottleOptions) *AdaptiveThrottle {
+	if options.ProbeInterval <= 0 {
+		options.ProbeInterval = 1 * time.Minute
+	}
+	initial := options.InitialRate
+	if initial == 0 {
+		initial = options.Base.GetOptions().GetMaxCount()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &AdaptiveThrottle{
+		base:    options.Base,
+		options: options,
+		rate:    initial,
+		cancel:  cancel,
+	}
+	if options.Probe == nil {
+		a.logger().Printf("adaptive throttle: no Probe configured, effective rate is fixed at %d", initial)
+		return a
+	}
+	go a.probeLoop(ctx)
+	return a
+}
+
+// probeLoop - poll Probe on ProbeInterval, applying the AIMD adjustment to
+// the effective rate until ctx is cancelled by Stop
+func (a *AdaptiveThrottle) probeLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.options.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.probeOnce(ctx)
+		}
+	}
+}
+
+// probeOnce - poll Probe once and apply the resulting AIMD adjustment
+func (a *AdaptiveThrottle) probeOnce(ctx context.Context) {
+	health, err := a.options.Probe(ctx)
+	if err != nil {
+		a.logger().Printf("adaptive throttle: probe error: %s", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case health >= a.options.GoodThreshold:
+		a.rate += a.options.Step
+		if a.options.MaxRate > 0 && a.rate > a.options.MaxRate {
+			a.rate = a.options.MaxRate
+		}
+	case health <= a.options.BadThreshold:
+		a.rate = int(float64(a.rate) * a.options.DecreaseFactor)
+		if a.rate < a.options.MinRate {
+			a.rate = a.options.MinRate
+		}
+	}
+	a.lastHealth = health
+	a.lastProbeAt = time.Now()
+}
+
+// GetEffectiveMaxCount - the current AIMD-adjusted max count
+func (a *AdaptiveThrottle) GetEffectiveMaxCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.rate
+}
+
+// AdaptiveThrottleStats - a snapshot of an AdaptiveThrottle's controller
+// state, suitable for exposing on a /metrics-style endpoint
+type AdaptiveThrottleStats struct {
+	EffectiveMaxCount int
+	LastHealth        float64
+	LastProbeAt       time.Time
+}
+
+// Stats - snapshot the controller's current state
+func (a *AdaptiveThrottle) Stats() AdaptiveThrottleStats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return AdaptiveThrottleStats{
+		EffectiveMaxCount: a.rate,
+		LastHealth:        a.lastHealth,
+		LastProbeAt:       a.lastProbeAt,
+	}
+}
+
+// Stop - cancel the probe loop and stop the wrapped Base throttle.  Safe to
+// call once.  It does not wait for the probe loop to exit, so a Probe call
+// already in flight can still apply one last adjustment after Stop returns;
+// after that the effective rate stays frozen
+func (a *AdaptiveThrottle) Stop() {
+	a.cancel()
+	a.base.Stop()
+}
+
+// logger - the configured Logger, or DefaultLogger when none was given
+func (a *AdaptiveThrottle) logger() Logger {
+	if a.options.Logger != nil {
+		return a.options.Logger
+	}
+	return DefaultLogger
+}
+
+// metrics - the configured Metrics, or the Base throttle's when none was
+// given
+func (a *AdaptiveThrottle) metrics() Metrics {
+	if a.options.Metrics != nil {
+		return a.options.Metrics
+	}
+	return a.base.GetOptions().Metrics()
+}
+
+// GetOptions - implementation of Throttle.  Delegates everything to the
+// Base throttle's options except GetMaxCount and GetLimiter, which reflect
+// the current AIMD-adjusted rate instead of Base's fixed configuration, and
+// Logger and Metrics, which are the AdaptiveThrottle's own
+func (a *AdaptiveThrottle) GetOptions() ThrottleOptions {
+	return &adaptiveThrottleOptions{base: a.base.GetOptions(), a: a}
+}
+
+// ShouldThrottle - implementation of Throttle.  This does not delegate to
+// Base.ShouldThrottle, since Base's own options have a fixed max count
+// baked in; instead it repeats the fixed-window check dampenerThrottle
+// performs, but against a Limiter rebuilt from the current effective max
+// count on every call
+func (a *AdaptiveThrottle) ShouldThrottle(r *http.Request) (ThrottleCheckResult, error) {
+	options := a.GetOptions()
+	if !options.MatchCriteria()(r) {
+		return ThrottleCheckResult{}, nil
+	}
+	allowed, remaining, retryAfter, err := options.GetLimiter().Allow(options.GetPrefix(), time.Now(), 1)
+	if err != nil {
+		a.metrics().StorageErrors()
+		return ThrottleCheckResult{}, err
+	}
+	if !allowed {
+		a.metrics().Throttled()
+		return ThrottleCheckResult{
+			ShouldThrottle: true,
+			Reason:         "adaptive rate limit exceeded",
+			ReasonHint:     AdaptiveBackpressure,
+			RetryAfter:     retryAfter,
+			Remaining:      remaining,
+		}, nil
+	}
+	a.metrics().Allowed()
+	return ThrottleCheckResult{Remaining: remaining}, nil
+}
+
+// AppendEvent - implementation of Throttle, delegated straight to Base
+func (a *AdaptiveThrottle) AppendEvent(r *http.Request) {
+	a.base.AppendEvent(r)
+}
+
+// adaptiveThrottleOptions - implementation of ThrottleOptions returned by
+// AdaptiveThrottle.GetOptions
+type adaptiveThrottleOptions struct {
+	base ThrottleOptions
+	a    *AdaptiveThrottle
+}
+
+// GetPrefix - implementation of ThrottleOptions, delegated to base
+func (o *adaptiveThrottleOptions) GetPrefix() string {
+	return o.base.GetPrefix()
+}
+
+// GetMaxCount - implementation of ThrottleOptions; the current AIMD-adjusted
+// rate, in place of base's fixed configuration
+func (o *adaptiveThrottleOptions) GetMaxCount() int {
+	return o.a.GetEffectiveMaxCount()
+}
+
+// GetDuration - implementation of ThrottleOptions, delegated to base
+func (o *adaptiveThrottleOptions) GetDuration() time.Duration {
+	return o.base.GetDuration()
+}
+
+// MatchCriteria - implementation of ThrottleOptions, delegated to base
+func (o *adaptiveThrottleOptions) MatchCriteria() func(*http.Request) bool {
+	return o.base.MatchCriteria()
+}
+
+// GetStorage - implementation of ThrottleOptions, delegated to base
+func (o *adaptiveThrottleOptions) GetStorage() Storage {
+	return o.base.GetStorage()
+}
+
+// GetLimiter - implementation of ThrottleOptions.  Always a fresh
+// fixed-window Limiter built from the current AIMD-adjusted rate, so a
+// changing rate takes effect on the very next request
+func (o *adaptiveThrottleOptions) GetLimiter() Limiter {
+	return NewFixedWindowLimiter(o.base.GetStorage(), o.a.GetEffectiveMaxCount(), o.base.GetDuration())
+}
+
+// CleanupInterval - implementation of ThrottleOptions, delegated to base
+func (o *adaptiveThrottleOptions) CleanupInterval() time.Duration {
+	return o.base.CleanupInterval()
+}
+
+// Logger - implementation of ThrottleOptions, the AdaptiveThrottle's own
+// Logger
+func (o *adaptiveThrottleOptions) Logger() Logger {
+	return o.a.logger()
+}
+
+// Metrics - implementation of ThrottleOptions, the AdaptiveThrottle's own
+// Metrics
+func (o *adaptiveThrottleOptions) Metrics() Metrics {
+	return o.a.metrics()
+}
+
+// HTTPHealthProbe - a demo Probe reading a health signal in [0, 1] from the
+// plain-text body of an HTTP endpoint, e.g. a small handler in front of a
+// Prometheus gauge such as replica lag normalized to [0, 1].  Any body that
+// doesn't parse as a float, or a non-200 response, is treated as unhealthy
+func HTTPHealthProbe(client *http.Client, url string) func(context.Context) (float64, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) (float64, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		health, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+		if err != nil {
+			return 0, nil
+		}
+		return health, nil
+	}
+}
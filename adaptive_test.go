@@ -0,0 +1,69 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/husobee/dampener"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdaptiveThrottle(t *testing.T) {
+	Convey("Test creating a NewAdaptiveThrottle", t, func() {
+		base := dampener.NewThrottle(
+			dampener.NewThrottleOptions(
+				"IPAddress", 10, 1*time.Minute,
+				func(r *http.Request) bool { return true },
+				dampener.NewMemoryStorage()))
+
+		var health int64 // 0 or 1, flipped per-test and read back by Probe
+
+		adaptive := dampener.NewAdaptiveThrottle(dampener.AdaptiveThrottleOptions{
+			Base:           base,
+			ProbeInterval:  5 * time.Millisecond,
+			GoodThreshold:  0.8,
+			BadThreshold:   0.2,
+			Step:           2,
+			DecreaseFactor: 0.5,
+			MinRate:        1,
+			MaxRate:        20,
+			InitialRate:    10,
+			Probe: func(ctx context.Context) (float64, error) {
+				if atomic.LoadInt64(&health) == 0 {
+					return 0.0, nil
+				}
+				return 1.0, nil
+			},
+		})
+		defer adaptive.Stop()
+
+		Convey("increases the effective max count while the probe reports healthy", func() {
+			atomic.StoreInt64(&health, 1)
+			So(waitFor(func() bool { return adaptive.GetEffectiveMaxCount() > 10 }), ShouldBeTrue)
+		})
+
+		Convey("decreases the effective max count once the probe reports unhealthy", func() {
+			atomic.StoreInt64(&health, 0)
+			So(waitFor(func() bool { return adaptive.GetEffectiveMaxCount() < 10 }), ShouldBeTrue)
+			stats := adaptive.Stats()
+			So(stats.LastHealth, ShouldEqual, 0.0)
+		})
+	})
+}
+
+// waitFor - poll cond every millisecond for up to a second, for asserting on
+// AdaptiveThrottle's background probe loop without a fixed sleep
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	return false
+}
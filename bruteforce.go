@@ -0,0 +1,288 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// outcomeContextKey - unexported type for the context key that carries an
+// outcomeHolder, so it can't collide with keys set by other packages
+type outcomeContextKey struct{}
+
+// outcomeHolder - a mutable box for the Outcome a handler reports via
+// MarkSuccess/MarkFailure.  A plain context.WithValue can't be used to carry
+// the outcome itself, since the handler only has the *http.Request the
+// throttler handed it and can't replace its context for the throttler to
+// see; a pointer to a shared holder lets the handler mutate state the
+// throttler reads back after ServeHTTP's call to next returns
+type outcomeHolder struct {
+	mu  sync.Mutex
+	set bool
+	o   Outcome
+}
+
+// withOutcomeHolder - attach a fresh outcomeHolder to r's context
+func withOutcomeHolder(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), outcomeContextKey{}, &outcomeHolder{}))
+}
+
+// outcomeFromRequest - read back the Outcome a handler reported for r, if
+// any.  ok is false if no handler called MarkSuccess/MarkFailure
+func outcomeFromRequest(r *http.Request) (outcome Outcome, ok bool) {
+	h, _ := r.Context().Value(outcomeContextKey{}).(*outcomeHolder)
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.o, h.set
+}
+
+// MarkSuccess - report that the operation performed for r succeeded, for
+// throttles such as BruteforceThrottle that key their decision off outcomes
+// rather than raw request volume.  Must be called with the *http.Request the
+// throttled handler received, since the outcome is threaded through its
+// context
+func MarkSuccess(r *http.Request) {
+	setOutcome(r, Success)
+}
+
+// MarkFailure - report that the operation performed for r failed.  See
+// MarkSuccess
+func MarkFailure(r *http.Request) {
+	setOutcome(r, Failure)
+}
+
+// setOutcome - store outcome on r's outcomeHolder, if it has one.  A request
+// that never passed through a dampenerPolicy has no holder, so this is a
+// no-op rather than a panic
+func setOutcome(r *http.Request, outcome Outcome) {
+	h, _ := r.Context().Value(outcomeContextKey{}).(*outcomeHolder)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.o = outcome
+	h.set = true
+}
+
+// BruteforceThrottleOptions - configuration for NewBruteforceThrottle.
+// Unlike ThrottleOptions, this is a plain struct: the option set here is
+// specific to bruteforce detection and there is no other implementation to
+// abstract over
+type BruteforceThrottleOptions struct {
+	// Prefix - key this throttle's failure counter is stored under
+	Prefix string
+	// Storage - backend used to record outcomes and count failures
+	Storage Storage
+	// MatchCriteria - which requests this throttle applies to.  Defaults to
+	// matching every request when nil
+	MatchCriteria func(*http.Request) bool
+	// Allowlist - requests for which this throttle should never delay or
+	// reject, even over Threshold.  Optional
+	Allowlist func(*http.Request) bool
+	// Threshold - number of failures allowed within Window before requests
+	// start being delayed
+	Threshold int
+	// Window - trailing duration failures are counted over
+	Window time.Duration
+	// BaseDelay - scale of the delay: with n failures over Threshold a
+	// request is delayed BaseDelay * 2^n, so the first failure over
+	// Threshold is already delayed twice BaseDelay
+	BaseDelay time.Duration
+	// MaxDelay - delay is doubled for each additional failure over
+	// Threshold, capped at MaxDelay.  Once the computed delay reaches
+	// MaxDelay, the request is rejected outright instead of held open for
+	// that long
+	MaxDelay time.Duration
+	// CooldownAfter - once a success is recorded, failures are ignored for
+	// this long afterward, giving the counter a chance to clear
+	CooldownAfter time.Duration
+	// Logger - defaults to DefaultLogger when nil
+	Logger Logger
+	// Metrics - defaults to a no-op implementation when nil
+	Metrics Metrics
+}
+
+// bruteforceThrottleOptions - implementation of ThrottleOptions wrapping a
+// BruteforceThrottleOptions.  Threshold stands in for GetMaxCount and Window
+// for GetDuration, so the X-RateLimit-* headers dampenerPolicy writes stay
+// meaningful for this throttle type too
+type bruteforceThrottleOptions struct {
+	o BruteforceThrottleOptions
+}
+
+// GetPrefix - implementation of ThrottleOptions
+func (b *bruteforceThrottleOptions) GetPrefix() string {
+	return b.o.Prefix
+}
+
+// GetMaxCount - implementation of ThrottleOptions; stands in for Threshold
+func (b *bruteforceThrottleOptions) GetMaxCount() int {
+	return b.o.Threshold
+}
+
+// GetDuration - implementation of ThrottleOptions; stands in for Window
+func (b *bruteforceThrottleOptions) GetDuration() time.Duration {
+	return b.o.Window
+}
+
+// MatchCriteria - implementation of ThrottleOptions
+func (b *bruteforceThrottleOptions) MatchCriteria() func(*http.Request) bool {
+	return b.o.MatchCriteria
+}
+
+// GetStorage - implementation of ThrottleOptions
+func (b *bruteforceThrottleOptions) GetStorage() Storage {
+	return b.o.Storage
+}
+
+// GetLimiter - implementation of ThrottleOptions.  Not used by
+// bruteforceThrottle itself, which counts failures directly; provided so
+// generic code that inspects any Throttle's Limiter still gets something
+// sensible back
+func (b *bruteforceThrottleOptions) GetLimiter() Limiter {
+	return NewFixedWindowLimiter(b.o.Storage, b.o.Threshold, b.o.Window)
+}
+
+// successKey - key the cooldown marker is stored under: Prefix with a
+// ":success" suffix, so the markers are kept apart from Prefix's own events
+func (b *bruteforceThrottleOptions) successKey() string {
+	return b.o.Prefix + ":success"
+}
+
+// CleanupInterval - implementation of ThrottleOptions.  Not used by
+// bruteforceThrottle, which starts no background cleanup of its own; kept at
+// the same default as dampenerThrottle for consistency
+func (b *bruteforceThrottleOptions) CleanupInterval() time.Duration {
+	return 1 * time.Minute
+}
+
+// Logger - implementation of ThrottleOptions
+func (b *bruteforceThrottleOptions) Logger() Logger {
+	if b.o.Logger != nil {
+		return b.o.Logger
+	}
+	return DefaultLogger
+}
+
+// Metrics - implementation of ThrottleOptions
+func (b *bruteforceThrottleOptions) Metrics() Metrics {
+	if b.o.Metrics != nil {
+		return b.o.Metrics
+	}
+	return noopMetrics{}
+}
+
+// bruteforceThrottle - implementation of Throttle aimed at auth-style
+// endpoints.  It counts Failure outcomes reported via MarkFailure rather
+// than raw request volume, and punishes repeat offenders with an
+// exponentially growing delay instead of an outright rejection, until the
+// delay saturates at MaxDelay
+type bruteforceThrottle struct {
+	options *bruteforceThrottleOptions
+}
+
+// NewBruteforceThrottle - patchable entry point for getting a new
+// bruteforce-detection throttle
+var NewBruteforceThrottle = newBruteforceThrottle
+
+// newBruteforceThrottle - create a new BruteforceThrottle
+func newBruteforceThrottle(options BruteforceThrottleOptions) Throttle {
+	if options.MatchCriteria == nil {
+		options.MatchCriteria = func(*http.Request) bool { return true }
+	}
+	return &bruteforceThrottle{options: &bruteforceThrottleOptions{o: options}}
+}
+
+// GetOptions - get options from the throttle
+func (b *bruteforceThrottle) GetOptions() ThrottleOptions {
+	return b.options
+}
+
+// ShouldThrottle - should throttle?  Failures are counted only while the
+// request matches MatchCriteria and isn't Allowlisted; a recent success
+// suppresses the check entirely for CooldownAfter
+func (b *bruteforceThrottle) ShouldThrottle(r *http.Request) (ThrottleCheckResult, error) {
+	o := b.options.o
+	if !o.MatchCriteria(r) {
+		return ThrottleCheckResult{}, nil
+	}
+	if o.Allowlist != nil && o.Allowlist(r) {
+		return ThrottleCheckResult{}, nil
+	}
+	if o.CooldownAfter > 0 {
+		recent, err := o.Storage.EventsInDuration(b.options.successKey(), o.CooldownAfter)
+		if err != nil {
+			b.options.Metrics().StorageErrors()
+			return ThrottleCheckResult{}, err
+		}
+		if recent > 0 {
+			b.options.Metrics().Allowed()
+			return ThrottleCheckResult{}, nil
+		}
+	}
+	failures, err := o.Storage.FailuresInDuration(o.Prefix, o.Window)
+	if err != nil {
+		b.options.Metrics().StorageErrors()
+		return ThrottleCheckResult{}, err
+	}
+	if failures <= int64(o.Threshold) {
+		b.options.Metrics().Allowed()
+		return ThrottleCheckResult{Remaining: int64(o.Threshold) - failures}, nil
+	}
+	over := uint(failures - int64(o.Threshold))
+	delay := o.BaseDelay * time.Duration(int64(1)<<over)
+	if delay <= 0 || delay >= o.MaxDelay {
+		// delay has saturated: holding the connection open for MaxDelay
+		// would tie up a server goroutine, so reject outright instead
+		b.options.Metrics().Throttled()
+		return ThrottleCheckResult{
+			ShouldThrottle: true,
+			Reason:         "bruteforce suspected",
+			ReasonHint:     BruteforceSuspected,
+			RetryAfter:     o.MaxDelay,
+		}, nil
+	}
+	b.options.Metrics().Throttled()
+	return ThrottleCheckResult{
+		Reason:     "bruteforce suspected",
+		ReasonHint: BruteforceSuspected,
+		RetryAfter: delay,
+	}, nil
+}
+
+// Stop - implementation of Throttle.  bruteforceThrottle starts no
+// background goroutine of its own, so this is a no-op
+func (b *bruteforceThrottle) Stop() {}
+
+// AppendEvent - record the outcome MarkSuccess/MarkFailure reported for r,
+// once next has run.  A request that matched no MatchCriteria, or whose
+// handler never reported an outcome, has nothing to record
+func (b *bruteforceThrottle) AppendEvent(r *http.Request) {
+	o := b.options.o
+	if !o.MatchCriteria(r) || (o.Allowlist != nil && o.Allowlist(r)) {
+		return
+	}
+	outcome, ok := outcomeFromRequest(r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if err := o.Storage.AppendEventWithOutcome(o.Prefix, now, outcome); err != nil {
+		b.options.Logger().Printf("error recording bruteforce outcome: %s", err)
+		b.options.Metrics().StorageErrors()
+		return
+	}
+	if outcome == Success && o.CooldownAfter > 0 {
+		if err := o.Storage.AppendEvent(b.options.successKey(), now); err != nil {
+			b.options.Logger().Printf("error recording bruteforce cooldown: %s", err)
+			b.options.Metrics().StorageErrors()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/husobee/dampener"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBruteforceThrottle(t *testing.T) {
+	Convey("Test creating a NewBruteforceThrottle", t, func() {
+		storage := dampener.NewMemoryStorage()
+		throttle := dampener.NewBruteforceThrottle(dampener.BruteforceThrottleOptions{
+			Prefix:    "login",
+			Storage:   storage,
+			Threshold: 2,
+			Window:    1 * time.Minute,
+			BaseDelay: 1 * time.Millisecond,
+			MaxDelay:  100 * time.Millisecond,
+		})
+		req, _ := http.NewRequest("POST", "/login", nil)
+
+		Convey("allows requests while failures stay within the threshold", func() {
+			for i := 0; i < 2; i++ {
+				storage.AppendEventWithOutcome("login", time.Now(), dampener.Failure)
+			}
+			result, err := throttle.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeFalse)
+		})
+
+		Convey("delays, then rejects, once failures exceed the threshold", func() {
+			for i := 0; i < 3; i++ {
+				storage.AppendEventWithOutcome("login", time.Now(), dampener.Failure)
+			}
+			result, err := throttle.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeFalse)
+			So(result.RetryAfter, ShouldBeGreaterThan, 0)
+
+			for i := 0; i < 10; i++ {
+				storage.AppendEventWithOutcome("login", time.Now(), dampener.Failure)
+			}
+			result, err = throttle.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeTrue)
+		})
+	})
+}
@@ -4,13 +4,52 @@
 package dampener
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// Logger - interface for injectable logging, so callers can route dampener's
+// diagnostic output through their own logging stack rather than the
+// standard library's log package
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// DefaultLogger - Logger used wherever none is explicitly configured
+var DefaultLogger Logger = stdLogger{}
+
+// stdLogger - Logger backed by the standard library's log package; the
+// behaviour dampener had before Logger was injectable
+type stdLogger struct{}
+
+// Printf - implementation of Logger for stdLogger
+func (stdLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// Metrics - optional counters a Throttle reports through, so operators can
+// wire dampener up to Prometheus or similar without this module importing
+// that dependency
+type Metrics interface {
+	Allowed()
+	Throttled()
+	CleanupRuns()
+	StorageErrors()
+}
+
+// noopMetrics - Metrics used wherever none is explicitly configured
+type noopMetrics struct{}
+
+func (noopMetrics) Allowed()       {}
+func (noopMetrics) Throttled()     {}
+func (noopMetrics) CleanupRuns()   {}
+func (noopMetrics) StorageErrors() {}
+
 // ThrottlerOptions - Interface that defines what a throttler option should be able to do
 type ThrottlerOptions interface {
 	GetStatus() int
@@ -70,20 +109,72 @@ func newDampenerPolicy(next http.Handler, options ThrottlerOptions, throttles ..
 	}
 }
 
+// throttleResponseBody - JSON body written when a request is throttled,
+// carrying the reason a ThrottleCheckResult was rejected
+type throttleResponseBody struct {
+	Reason     string     `json:"reason"`
+	ReasonHint ReasonHint `json:"reasonHint"`
+}
+
 // ServeHTTP - implementation of a Throttler for damenerPolicy
 func (d *dampenerPolicy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// give handlers a way to report success/failure back to throttles such
+	// as BruteforceThrottle via MarkSuccess/MarkFailure
+	r = withOutcomeHolder(r)
+
 	// foreach of the throttles we are watching, check if this request
 	// should be throttled.
+	var delay time.Duration
 	for _, t := range d.throttles {
-		if yes, _ := t.ShouldThrottle(r); yes {
-			log.Printf("should throttle, sending response")
-			w.WriteHeader(d.GetOptions().GetStatus())
-			w.Write(d.GetOptions().GetMessage())
+		result, err := t.ShouldThrottle(r)
+		if err != nil {
+			t.GetOptions().Logger().Printf("error checking throttle: %s", err)
+			continue
+		}
+		if result.ShouldThrottle {
+			t.GetOptions().Logger().Printf("should throttle, sending response: %s", result.Reason)
+			responseOptions := d.GetOptions()
+			if result.ResponseOptions != nil {
+				// a ThrottleGroup rule asked for its own status/message
+				// rather than the policy's default
+				responseOptions = result.ResponseOptions
+			}
+			if result.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.RetryAfter).Unix(), 10))
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(t.GetOptions().GetMaxCount()))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(responseOptions.GetStatus())
+			reason := result.Reason
+			if reason == "" {
+				reason = string(responseOptions.GetMessage())
+			}
+			body, _ := json.Marshal(throttleResponseBody{Reason: reason, ReasonHint: result.ReasonHint})
+			w.Write(body)
 			return
 		}
-		t.AppendEvent(r)
+		if result.RetryAfter > delay {
+			delay = result.RetryAfter
+		}
+	}
+	if delay > 0 {
+		// allowed, but a throttle such as BruteforceThrottle asked for the
+		// request to be slowed down rather than rejected outright
+		time.Sleep(delay)
 	}
 	d.next.ServeHTTP(w, r)
+	// now that next has run, throttles that need the outcome of the request
+	// (e.g. BruteforceThrottle, via MarkSuccess/MarkFailure) can record it.
+	// Only throttles that actually matched this request are notified, so a
+	// throttle whose MatchCriteria excluded this request never has its
+	// bookkeeping touched
+	for _, t := range d.throttles {
+		if t.GetOptions().MatchCriteria()(r) {
+			t.AppendEvent(r)
+		}
+	}
 }
 
 // GetThrottles - implementation of a Throttler for DamenerPolicy
@@ -103,12 +194,22 @@ type ThrottleOptions interface {
 	GetDuration() time.Duration
 	MatchCriteria() func(*http.Request) bool
 	GetStorage() Storage
+	GetLimiter() Limiter
+
+	// CleanupInterval - how often a Throttle's background storage cleanup,
+	// if it has one, should run
+	CleanupInterval() time.Duration
+	// Logger - where a Throttle sends its diagnostic output
+	Logger() Logger
+	// Metrics - where a Throttle reports its counters.  Never nil
+	Metrics() Metrics
 }
 
 // NewThrottleOptions - patchable entry point for getting new throttle options
 var NewThrottleOptions = newDampenerThrottleOptions
 
-// newDampenerThrottleOptions - create a new dampenerThrottleOptions
+// newDampenerThrottleOptions - create a new dampenerThrottleOptions, backed by
+// the original fixed-window Limiter
 func newDampenerThrottleOptions(prefix string, count int, duration time.Duration, f func(*http.Request) bool, storage Storage) ThrottleOptions {
 	return &dampenerThrottleOptions{
 		prefix:   prefix,
@@ -119,13 +220,71 @@ func newDampenerThrottleOptions(prefix string, count int, duration time.Duration
 	}
 }
 
+// NewThrottleOptionsWithLimiter - patchable entry point for getting new
+// throttle options backed by an explicit Limiter strategy, such as the GCRA
+// or sliding window implementations
+var NewThrottleOptionsWithLimiter = newDampenerThrottleOptionsWithLimiter
+
+// newDampenerThrottleOptionsWithLimiter - create a new dampenerThrottleOptions
+// with a caller-supplied Limiter instead of the default fixed-window one
+func newDampenerThrottleOptionsWithLimiter(prefix string, count int, duration time.Duration, f func(*http.Request) bool, storage Storage, limiter Limiter) ThrottleOptions {
+	return &dampenerThrottleOptions{
+		prefix:   prefix,
+		duration: duration,
+		f:        f,
+		storage:  storage,
+		max:      count,
+		limiter:  limiter,
+	}
+}
+
+// ThrottleLifecycleOptions - the optional lifecycle/observability knobs
+// grouped under NewThrottleOptionsWithLifecycle, kept out of the core
+// NewThrottleOptions/NewThrottleOptionsWithLimiter constructors so existing
+// callers of those are unaffected
+type ThrottleLifecycleOptions struct {
+	// Limiter - as with NewThrottleOptionsWithLimiter; nil falls back to the
+	// original fixed-window Limiter
+	Limiter Limiter
+	// CleanupInterval - defaults to one minute when zero
+	CleanupInterval time.Duration
+	// Logger - defaults to DefaultLogger when nil
+	Logger Logger
+	// Metrics - defaults to a no-op implementation when nil
+	Metrics Metrics
+}
+
+// NewThrottleOptionsWithLifecycle - patchable entry point for getting new
+// throttle options with cleanup interval, Logger and Metrics configured
+var NewThrottleOptionsWithLifecycle = newDampenerThrottleOptionsWithLifecycle
+
+// newDampenerThrottleOptionsWithLifecycle - create a new
+// dampenerThrottleOptions with the lifecycle/observability knobs set
+func newDampenerThrottleOptionsWithLifecycle(prefix string, count int, duration time.Duration, f func(*http.Request) bool, storage Storage, lifecycle ThrottleLifecycleOptions) ThrottleOptions {
+	return &dampenerThrottleOptions{
+		prefix:          prefix,
+		duration:        duration,
+		f:               f,
+		storage:         storage,
+		max:             count,
+		limiter:         lifecycle.Limiter,
+		cleanupInterval: lifecycle.CleanupInterval,
+		logger:          lifecycle.Logger,
+		metrics:         lifecycle.Metrics,
+	}
+}
+
 // dampenerThrottleOptions - implementation of ThrottleOptions
 type dampenerThrottleOptions struct {
-	prefix   string
-	duration time.Duration
-	f        func(*http.Request) bool
-	storage  Storage
-	max      int
+	prefix          string
+	duration        time.Duration
+	f               func(*http.Request) bool
+	storage         Storage
+	max             int
+	limiter         Limiter
+	cleanupInterval time.Duration
+	logger          Logger
+	metrics         Metrics
 }
 
 // GetPrefix - implementation of ThrottleOptions
@@ -153,64 +312,205 @@ func (d *dampenerThrottleOptions) GetMaxCount() int {
 	return d.max
 }
 
+// GetLimiter - implementation of ThrottleOptions.  Falls back to the
+// original fixed-window Limiter when none was explicitly configured, so
+// existing callers of NewThrottleOptions keep their current behaviour
+func (d *dampenerThrottleOptions) GetLimiter() Limiter {
+	if d.limiter != nil {
+		return d.limiter
+	}
+	return NewFixedWindowLimiter(d.storage, d.max, d.duration)
+}
+
+// CleanupInterval - implementation of ThrottleOptions; defaults to one
+// minute when unset
+func (d *dampenerThrottleOptions) CleanupInterval() time.Duration {
+	if d.cleanupInterval > 0 {
+		return d.cleanupInterval
+	}
+	return 1 * time.Minute
+}
+
+// Logger - implementation of ThrottleOptions
+func (d *dampenerThrottleOptions) Logger() Logger {
+	if d.logger != nil {
+		return d.logger
+	}
+	return DefaultLogger
+}
+
+// Metrics - implementation of ThrottleOptions
+func (d *dampenerThrottleOptions) Metrics() Metrics {
+	if d.metrics != nil {
+		return d.metrics
+	}
+	return noopMetrics{}
+}
+
+// ReasonHint - typed hint describing why a throttle reached its decision,
+// so callers can react programmatically without parsing Reason strings
+type ReasonHint int
+
+const (
+	// NoHint - no particular reason was given for the decision
+	NoHint ReasonHint = iota
+	// RateLimitExceeded - a fixed count/window style limit was exceeded
+	RateLimitExceeded
+	// BruteforceSuspected - repeated failures were detected for the key
+	BruteforceSuspected
+	// UserBlocked - the key has been explicitly denied
+	UserBlocked
+	// AdaptiveBackpressure - an external health signal lowered the allowed rate
+	AdaptiveBackpressure
+)
+
+// Outcome - the result of the operation a request was making, as signalled
+// by a handler via MarkSuccess/MarkFailure.  Consumed by BruteforceThrottle,
+// which only counts Failure outcomes toward its threshold
+type Outcome int
+
+const (
+	// Success - the handler's operation completed successfully
+	Success Outcome = iota
+	// Failure - the handler's operation failed, e.g. a rejected login attempt
+	Failure
+)
+
+// ThrottleCheckResult - the outcome of a ShouldThrottle check, carrying enough
+// detail for a Throttler to construct an informative response
+type ThrottleCheckResult struct {
+	ShouldThrottle bool
+	Reason         string
+	ReasonHint     ReasonHint
+	RetryAfter     time.Duration
+	Remaining      int64
+	// ResponseOptions - status code and message to use for this specific
+	// result, overriding the enclosing Throttler's own ThrottlerOptions.
+	// Nil defers to the Throttler.  Set by ThrottleGroup so each of its
+	// rules can produce a different response
+	ResponseOptions ThrottlerOptions
+}
+
 // Throttle - Interface to describe capabilities of a "throttle"
 type Throttle interface {
 	GetOptions() ThrottleOptions
-	ShouldThrottle(*http.Request) (bool, error)
+	ShouldThrottle(*http.Request) (ThrottleCheckResult, error)
 	AppendEvent(*http.Request)
+
+	// Stop - cancel any background work the Throttle started (e.g. a
+	// storage cleanup loop) and wait for it to exit.  Safe to call on a
+	// Throttle that started none; a no-op in that case
+	Stop()
 }
 
-// NewThrottle - patchable entry point for getting new throttle options
+// ShouldThrottleBool - adapts the ThrottleCheckResult based ShouldThrottle to
+// the original bool-returning signature, for callers that have not yet
+// migrated to the richer ThrottleCheckResult API
+func ShouldThrottleBool(t Throttle, r *http.Request) (bool, error) {
+	result, err := t.ShouldThrottle(r)
+	return result.ShouldThrottle, err
+}
+
+// NewThrottle - patchable entry point for getting a new throttle.
+// Equivalent to NewThrottleWithContext(context.Background(), options)
 var NewThrottle = newDampenerThrottle
 
+// newDampenerThrottle - new throttle implemenation
+func newDampenerThrottle(options ThrottleOptions) Throttle {
+	return newDampenerThrottleWithContext(context.Background(), options)
+}
+
+// NewThrottleWithContext - patchable entry point for getting a new throttle
+// whose cleanup loop is also cancelled when ctx is done, in addition to
+// Stop().  Useful for tying a throttle's lifetime to a parent context
+var NewThrottleWithContext = newDampenerThrottleWithContext
+
 // dampenerThrottle - implementation of throttle
 type dampenerThrottle struct {
 	options ThrottleOptions
+	cancel  context.CancelFunc
+	done    chan struct{}
 }
 
-// newDampenerThrottle - new throttle implemenation
-func newDampenerThrottle(options ThrottleOptions) Throttle {
+// newDampenerThrottleWithContext - new throttle implementation, running its
+// storage cleanup loop until ctx is done or Stop is called, whichever comes
+// first
+func newDampenerThrottleWithContext(ctx context.Context, options ThrottleOptions) Throttle {
+	ctx, cancel := context.WithCancel(ctx)
 	dt := &dampenerThrottle{
 		options: options,
+		cancel:  cancel,
+		done:    make(chan struct{}),
 	}
-	go func() {
-		// clean up the MemoryStorage
-		// should include a way to gracefully stop this
-		for {
-			time.Sleep(1 * time.Minute)
-			dt.GetOptions().GetStorage().Clean(
-				dt.GetOptions().GetPrefix(), time.Now())
-		}
-	}()
+	go dt.cleanupLoop(ctx)
 	return dt
 }
 
+// cleanupLoop - periodically clean the configured Storage until ctx is done
+func (d *dampenerThrottle) cleanupLoop(ctx context.Context) {
+	defer close(d.done)
+	ticker := time.NewTicker(d.GetOptions().CleanupInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.GetOptions().GetStorage().Clean(
+				d.GetOptions().GetPrefix(), time.Now()); err != nil {
+				d.GetOptions().Logger().Printf("error cleaning storage: %s", err)
+				d.GetOptions().Metrics().StorageErrors()
+				continue
+			}
+			d.GetOptions().Metrics().CleanupRuns()
+		}
+	}
+}
+
+// Stop - implementation of Throttle, cancelling the cleanup loop and
+// waiting for it to exit
+func (d *dampenerThrottle) Stop() {
+	d.cancel()
+	<-d.done
+}
+
 // GetOptions - get options from the throttle
 func (d *dampenerThrottle) GetOptions() ThrottleOptions {
 	return d.options
 }
 
-// ShouldThrottle - should throttle? from the throttle
-func (d *dampenerThrottle) ShouldThrottle(r *http.Request) (bool, error) {
+// ShouldThrottle - should throttle? from the throttle.  The decision and the
+// bookkeeping for it are delegated to the configured Limiter, which performs
+// the check and the corresponding storage update as a single operation
+func (d *dampenerThrottle) ShouldThrottle(r *http.Request) (ThrottleCheckResult, error) {
 	// is this something that matches our criteria?
 	if d.GetOptions().MatchCriteria()(r) {
-		// get count of events for this given prefix
-		count, err := d.GetOptions().GetStorage().EventsInDuration(d.GetOptions().GetPrefix(), d.GetOptions().GetDuration())
+		allowed, remaining, retryAfter, err := d.GetOptions().GetLimiter().Allow(
+			d.GetOptions().GetPrefix(), time.Now(), 1)
 		if err != nil {
-			return false, err
+			d.GetOptions().Metrics().StorageErrors()
+			return ThrottleCheckResult{}, err
 		}
-		fmt.Println("\n\nmax count: ", d.GetOptions().GetMaxCount(), count)
-		if count > int64(d.GetOptions().GetMaxCount()) {
-			return true, nil
+		if !allowed {
+			d.GetOptions().Metrics().Throttled()
+			return ThrottleCheckResult{
+				ShouldThrottle: true,
+				Reason:         "rate limit exceeded",
+				ReasonHint:     RateLimitExceeded,
+				RetryAfter:     retryAfter,
+				Remaining:      remaining,
+			}, nil
 		}
+		d.GetOptions().Metrics().Allowed()
+		return ThrottleCheckResult{Remaining: remaining}, nil
 	}
-	return false, nil
+	return ThrottleCheckResult{}, nil
 }
 
-// AppendEvent - append event to storage? from the throttle
-func (d *dampenerThrottle) AppendEvent(*http.Request) {
-	d.GetOptions().GetStorage().AppendEvent(d.GetOptions().GetPrefix(), time.Now())
-}
+// AppendEvent - a no-op for dampenerThrottle.  The Limiter records its own
+// bookkeeping atomically as part of ShouldThrottle, so there is nothing left
+// to append here; kept to satisfy the Throttle interface
+func (d *dampenerThrottle) AppendEvent(*http.Request) {}
 
 // Storage - Interface to implement to use various backends for storage
 // of throttling data.  Comes down to the ability to store new events, clean up
@@ -220,20 +520,58 @@ type Storage interface {
 	EventsInDuration(string, time.Duration) (int64, error)
 	AppendEvent(string, time.Time) error
 	Clean(string, time.Time) error
+
+	// AdvanceTAT atomically applies one step of the GCRA algorithm for key:
+	// given the previously stored theoretical arrival time (the zero Time if
+	// none was set), it advances it to at least now, adds increment, and
+	// commits the result only if that stays within burstWindow of now.
+	// Returns the TAT in effect afterwards (the new one if allowed, the
+	// unmodified one otherwise) and whether the step was allowed.  The
+	// check-and-commit happens as one atomic step so concurrent callers for
+	// the same key can't race each other.  Used by the GCRA Limiter
+	AdvanceTAT(key string, now time.Time, increment, burstWindow, ttl time.Duration) (tat time.Time, allowed bool, err error)
+
+	// WindowCount returns the event count recorded for the sliding-window
+	// bucket starting at windowStart, without modifying it
+	WindowCount(key string, windowStart time.Time) (int64, error)
+
+	// IncrementWindowCountIfBelow atomically adds by to the event count
+	// recorded for the sliding-window bucket starting at windowStart, but
+	// only if the count beforehand is at most ceiling; otherwise it leaves
+	// the count untouched.  Returns the count afterwards and whether it was
+	// incremented.  The check-and-increment happens as one atomic step so
+	// concurrent callers for the same key can't all pass the check before
+	// any of them commits.  Used by the sliding-window Limiter
+	IncrementWindowCountIfBelow(key string, windowStart time.Time, by int64, ttl time.Duration, ceiling float64) (count int64, allowed bool, err error)
+
+	// AppendEventWithOutcome records an Outcome for key at time t.  Used by
+	// BruteforceThrottle; implementations only need to retain enough history
+	// to answer FailuresInDuration for Failure outcomes
+	AppendEventWithOutcome(key string, t time.Time, outcome Outcome) error
+
+	// FailuresInDuration counts the Failure outcomes recorded for key within
+	// the trailing window d.  Used by BruteforceThrottle
+	FailuresInDuration(key string, d time.Duration) (int64, error)
 }
 
 // MemoryStorage - Dead Simple in memory storage example implementation of Storage
 // would recommend making a storage implementation using a real backend data store
 type MemoryStorage struct {
-	m *sync.RWMutex
-	s map[string][]time.Time
+	m        *sync.RWMutex
+	s        map[string][]time.Time
+	tat      map[string]time.Time
+	windows  map[string]map[int64]int64
+	failures map[string][]time.Time
 }
 
 // NewMemoryStorage - Create a new memory storage
 func NewMemoryStorage() Storage {
 	s := &MemoryStorage{
-		m: new(sync.RWMutex),
-		s: make(map[string][]time.Time),
+		m:        new(sync.RWMutex),
+		s:        make(map[string][]time.Time),
+		tat:      make(map[string]time.Time),
+		windows:  make(map[string]map[int64]int64),
+		failures: make(map[string][]time.Time),
 	}
 	return s
 }
@@ -276,3 +614,76 @@ func (s *MemoryStorage) Clean(k string, to time.Time) error {
 	}
 	return nil
 }
+
+// AdvanceTAT - implementing Storage Interface, atomically advancing the
+// stored theoretical arrival time for k under a single lock so the read,
+// the burst check, and the write can't interleave with another caller's.
+// ttl is unused; MemoryStorage keeps tat entries for its lifetime
+func (s *MemoryStorage) AdvanceTAT(k string, now time.Time, increment, burstWindow, ttl time.Duration) (time.Time, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	tat := s.tat[k]
+	if tat.Before(now) {
+		tat = now
+	}
+	candidate := tat.Add(increment)
+	if candidate.Sub(now) > burstWindow {
+		return tat, false, nil
+	}
+	s.tat[k] = candidate
+	return candidate, true, nil
+}
+
+// WindowCount - implementing Storage Interface, reading the event count
+// bucketed under windowStart for k without modifying it
+func (s *MemoryStorage) WindowCount(k string, windowStart time.Time) (int64, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.windows[k][windowStart.UnixNano()], nil
+}
+
+// IncrementWindowCountIfBelow - implementing Storage Interface, checking and
+// incrementing the event count bucketed under windowStart for k under a
+// single lock so the two can't race another caller's.  ttl is unused;
+// MemoryStorage keeps window buckets for its lifetime
+func (s *MemoryStorage) IncrementWindowCountIfBelow(k string, windowStart time.Time, by int64, ttl time.Duration, ceiling float64) (int64, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.windows[k]; !ok {
+		s.windows[k] = make(map[int64]int64)
+	}
+	bucket := windowStart.UnixNano()
+	if float64(s.windows[k][bucket]) > ceiling {
+		return s.windows[k][bucket], false, nil
+	}
+	s.windows[k][bucket] += by
+	return s.windows[k][bucket], true, nil
+}
+
+// AppendEventWithOutcome - implementing Storage Interface, recording a
+// Failure timestamp for k.  Success outcomes are not retained; nothing
+// currently counts them
+func (s *MemoryStorage) AppendEventWithOutcome(k string, t time.Time, outcome Outcome) error {
+	if outcome != Failure {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failures[k] = append(s.failures[k], t)
+	return nil
+}
+
+// FailuresInDuration - implementing Storage Interface, counting the Failure
+// timestamps recorded for k within the trailing duration d
+func (s *MemoryStorage) FailuresInDuration(k string, d time.Duration) (int64, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	var counter int64
+	cutoff := time.Now().Add(-1 * d)
+	for _, v := range s.failures[k] {
+		if v.After(cutoff) {
+			counter++
+		}
+	}
+	return counter, nil
+}
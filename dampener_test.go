@@ -21,16 +21,16 @@ func TestNewThrottler(t *testing.T) {
 					return true
 				},
 				dampener.NewMemoryStorage()))
-		Convey("set add event ot storage", func() {
+		defer throttle.Stop()
+		Convey("should throttle once the max count is exceeded", func() {
 			req, _ := http.NewRequest("GET", "/", nil)
-			for i := 0; i < 100; i++ {
-				throttle.AppendEvent(req)
+			var result dampener.ThrottleCheckResult
+			for i := 0; i < 101; i++ {
+				result, _ = throttle.ShouldThrottle(req)
+				So(result.ShouldThrottle, ShouldBeFalse)
 			}
-			beingThrottled, _ := throttle.ShouldThrottle(req)
-			So(beingThrottled, ShouldBeFalse)
-			throttle.AppendEvent(req)
-			beingThrottled, _ = throttle.ShouldThrottle(req)
-			So(beingThrottled, ShouldBeTrue)
+			result, _ = throttle.ShouldThrottle(req)
+			So(result.ShouldThrottle, ShouldBeTrue)
 		})
 	})
 }
@@ -0,0 +1,214 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener
+
+import (
+	"net/http"
+	"time"
+)
+
+// GroupMode - combinator semantics for a ThrottleGroup
+type GroupMode int
+
+const (
+	// Any - throttle the request once any matching rule would (OR).  The
+	// group stops evaluating further rules as soon as one matches and
+	// blocks, mirroring dampenerPolicy's own short-circuiting behaviour
+	Any GroupMode = iota
+	// All - throttle the request only once every matching rule would (AND).
+	// A rule whose MatchCriteria excludes the request takes no part in the
+	// vote; a group with no matching rules never throttles
+	All
+)
+
+// ThrottleRule - one child of a ThrottleGroup: the Throttle itself, plus the
+// ThrottlerOptions (status code, message) to use for the response when this
+// rule is the one that decides the request should be throttled.  Options may
+// be left nil, in which case the enclosing Throttler's own ThrottlerOptions
+// apply, same as a bare (non-grouped) Throttle
+type ThrottleRule struct {
+	Throttle Throttle
+	Options  ThrottlerOptions
+}
+
+// ThrottleGroupOptions - configuration for NewThrottleGroup
+type ThrottleGroupOptions struct {
+	// Prefix, MaxCount, Duration - reported through GetOptions() purely for
+	// the X-RateLimit-* headers dampenerPolicy writes; a group has no
+	// storage counter of its own to report these authoritatively
+	Prefix   string
+	MaxCount int
+	Duration time.Duration
+	// Mode - Any (OR) or All (AND) combinator semantics
+	Mode GroupMode
+	// Rules - the child throttles this group combines.  A rule's Throttle
+	// may itself be a ThrottleGroup, so groups nest
+	Rules []ThrottleRule
+	// Logger - defaults to DefaultLogger when nil
+	Logger Logger
+	// Metrics - defaults to a no-op implementation when nil
+	Metrics Metrics
+}
+
+// throttleGroupOptions - implementation of ThrottleOptions wrapping a
+// ThrottleGroupOptions, following the same pattern as
+// bruteforceThrottleOptions/adaptiveThrottleOptions
+type throttleGroupOptions struct {
+	o ThrottleGroupOptions
+}
+
+// GetPrefix - implementation of ThrottleOptions
+func (g *throttleGroupOptions) GetPrefix() string {
+	return g.o.Prefix
+}
+
+// GetMaxCount - implementation of ThrottleOptions
+func (g *throttleGroupOptions) GetMaxCount() int {
+	return g.o.MaxCount
+}
+
+// GetDuration - implementation of ThrottleOptions
+func (g *throttleGroupOptions) GetDuration() time.Duration {
+	return g.o.Duration
+}
+
+// MatchCriteria - implementation of ThrottleOptions.  A request matches the
+// group if it matches any one of its rules; a rule excluded by its own
+// MatchCriteria simply takes no part in ShouldThrottle/AppendEvent either
+func (g *throttleGroupOptions) MatchCriteria() func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		for _, rule := range g.o.Rules {
+			if rule.Throttle.GetOptions().MatchCriteria()(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// GetStorage - implementation of ThrottleOptions.  A group has no storage of
+// its own; delegated to the first rule for callers that inspect it generically
+func (g *throttleGroupOptions) GetStorage() Storage {
+	if len(g.o.Rules) == 0 {
+		return nil
+	}
+	return g.o.Rules[0].Throttle.GetOptions().GetStorage()
+}
+
+// GetLimiter - implementation of ThrottleOptions.  Not used by throttleGroup
+// itself, which delegates the actual decision to its rules; delegated to the
+// first rule for the same reason as GetStorage
+func (g *throttleGroupOptions) GetLimiter() Limiter {
+	if len(g.o.Rules) == 0 {
+		return nil
+	}
+	return g.o.Rules[0].Throttle.GetOptions().GetLimiter()
+}
+
+// CleanupInterval - implementation of ThrottleOptions.  throttleGroup starts
+// no background cleanup of its own; kept at the package default
+func (g *throttleGroupOptions) CleanupInterval() time.Duration {
+	return 1 * time.Minute
+}
+
+// Logger - implementation of ThrottleOptions
+func (g *throttleGroupOptions) Logger() Logger {
+	if g.o.Logger != nil {
+		return g.o.Logger
+	}
+	return DefaultLogger
+}
+
+// Metrics - implementation of ThrottleOptions
+func (g *throttleGroupOptions) Metrics() Metrics {
+	if g.o.Metrics != nil {
+		return g.o.Metrics
+	}
+	return noopMetrics{}
+}
+
+// throttleGroup - implementation of Throttle that combines its Rules under
+// an Any (OR) or All (AND) combinator, so callers can express things like
+// "throttle only if IP AND user both exceed" that a flat list of throttles
+// on a Throttler can't
+type throttleGroup struct {
+	options *throttleGroupOptions
+}
+
+// NewThrottleGroup - patchable entry point for getting a new ThrottleGroup
+var NewThrottleGroup = newThrottleGroup
+
+// newThrottleGroup - create a new ThrottleGroup
+func newThrottleGroup(options ThrottleGroupOptions) Throttle {
+	return &throttleGroup{options: &throttleGroupOptions{o: options}}
+}
+
+// GetOptions - get options from the throttle
+func (g *throttleGroup) GetOptions() ThrottleOptions {
+	return g.options
+}
+
+// ShouldThrottle - evaluate every rule whose MatchCriteria matches r, and
+// combine their verdicts per Mode.  In Any mode, evaluation stops as soon as
+// a matching rule blocks; in All mode every matching rule must block.  A
+// rule's own RetryAfter, even when it doesn't block on its own, is tracked
+// so a soft-delay signal (as returned by BruteforceThrottle once over
+// threshold but short of MaxDelay) still reaches the caller
+func (g *throttleGroup) ShouldThrottle(r *http.Request) (ThrottleCheckResult, error) {
+	o := g.options.o
+	var (
+		matched        int
+		throttled      int
+		maxDelay       time.Duration
+		blockedResult  ThrottleCheckResult
+		blockedOptions ThrottlerOptions
+	)
+	for _, rule := range o.Rules {
+		if !rule.Throttle.GetOptions().MatchCriteria()(r) {
+			continue
+		}
+		matched++
+		result, err := rule.Throttle.ShouldThrottle(r)
+		if err != nil {
+			g.options.Metrics().StorageErrors()
+			return ThrottleCheckResult{}, err
+		}
+		if result.RetryAfter > maxDelay {
+			maxDelay = result.RetryAfter
+		}
+		if result.ShouldThrottle {
+			throttled++
+			blockedResult = result
+			blockedOptions = rule.Options
+			if o.Mode == Any {
+				break
+			}
+		}
+	}
+	if throttled > 0 && (o.Mode == Any || throttled == matched) {
+		g.options.Metrics().Throttled()
+		blockedResult.ResponseOptions = blockedOptions
+		return blockedResult, nil
+	}
+	g.options.Metrics().Allowed()
+	return ThrottleCheckResult{RetryAfter: maxDelay}, nil
+}
+
+// AppendEvent - record the event only on rules that actually matched r, via
+// their own MatchCriteria, so a rule this request never touched has no
+// bookkeeping recorded against it
+func (g *throttleGroup) AppendEvent(r *http.Request) {
+	for _, rule := range g.options.o.Rules {
+		if !rule.Throttle.GetOptions().MatchCriteria()(r) {
+			continue
+		}
+		rule.Throttle.AppendEvent(r)
+	}
+}
+
+// Stop - stop every rule's Throttle in turn
+func (g *throttleGroup) Stop() {
+	for _, rule := range g.options.o.Rules {
+		rule.Throttle.Stop()
+	}
+}
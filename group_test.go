@@ -0,0 +1,158 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/husobee/dampener"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestThrottleGroup(t *testing.T) {
+	Convey("Test creating a NewThrottleGroup", t, func() {
+		req, _ := http.NewRequest("GET", "/", nil)
+
+		matchAll := func(r *http.Request) bool { return true }
+		matchNone := func(r *http.Request) bool { return false }
+
+		blocking := func() dampener.Throttle {
+			return &dampener.MockThrottle{
+				MockGetOptions: func() dampener.ThrottleOptions {
+					return &dampener.MockThrottleOptions{MockMatchCriteria: func() func(*http.Request) bool { return matchAll }}
+				},
+				MockShouldThrottleResult: func(*http.Request) (dampener.ThrottleCheckResult, error) {
+					return dampener.ThrottleCheckResult{ShouldThrottle: true, Reason: "blocked"}, nil
+				},
+			}
+		}
+		allowing := func() dampener.Throttle {
+			return &dampener.MockThrottle{
+				MockGetOptions: func() dampener.ThrottleOptions {
+					return &dampener.MockThrottleOptions{MockMatchCriteria: func() func(*http.Request) bool { return matchAll }}
+				},
+				MockShouldThrottleResult: func(*http.Request) (dampener.ThrottleCheckResult, error) {
+					return dampener.ThrottleCheckResult{}, nil
+				},
+			}
+		}
+
+		Convey("Any mode throttles once at least one matching rule blocks", func() {
+			group := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.Any,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: allowing()},
+					{Throttle: blocking()},
+				},
+			})
+			result, err := group.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeTrue)
+		})
+
+		Convey("All mode only throttles once every matching rule blocks", func() {
+			mixed := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.All,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: allowing()},
+					{Throttle: blocking()},
+				},
+			})
+			result, err := mixed.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeFalse)
+
+			bothBlocking := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.All,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: blocking()},
+					{Throttle: blocking()},
+				},
+			})
+			result, err = bothBlocking.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeTrue)
+		})
+
+		Convey("a rule excluded by its own MatchCriteria takes no part in the vote", func() {
+			nonMatching := &dampener.MockThrottle{
+				MockGetOptions: func() dampener.ThrottleOptions {
+					return &dampener.MockThrottleOptions{MockMatchCriteria: func() func(*http.Request) bool { return matchNone }}
+				},
+				MockShouldThrottleResult: func(*http.Request) (dampener.ThrottleCheckResult, error) {
+					return dampener.ThrottleCheckResult{ShouldThrottle: true}, nil
+				},
+			}
+			group := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.All,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: nonMatching},
+					{Throttle: allowing()},
+				},
+			})
+			result, err := group.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeFalse)
+		})
+
+		Convey("a blocking rule's own ThrottlerOptions are carried on the result", func() {
+			teapot := dampener.NewThrottlerOptions(http.StatusTeapot, []byte("no"))
+			group := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.Any,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: blocking(), Options: teapot},
+				},
+			})
+			result, err := group.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeTrue)
+			So(result.ResponseOptions, ShouldEqual, teapot)
+		})
+
+		Convey("AppendEvent only reaches rules whose MatchCriteria matched", func() {
+			var appended []string
+			matching := &dampener.MockThrottle{
+				MockGetOptions: func() dampener.ThrottleOptions {
+					return &dampener.MockThrottleOptions{MockMatchCriteria: func() func(*http.Request) bool { return matchAll }}
+				},
+				MockAppendEvent: func(*http.Request) { appended = append(appended, "matching") },
+			}
+			nonMatching := &dampener.MockThrottle{
+				MockGetOptions: func() dampener.ThrottleOptions {
+					return &dampener.MockThrottleOptions{MockMatchCriteria: func() func(*http.Request) bool { return matchNone }}
+				},
+				MockAppendEvent: func(*http.Request) { appended = append(appended, "nonMatching") },
+			}
+			group := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.Any,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: matching},
+					{Throttle: nonMatching},
+				},
+			})
+			group.AppendEvent(req)
+			So(appended, ShouldResemble, []string{"matching"})
+		})
+
+		Convey("groups nest: a group can be a rule of another group", func() {
+			inner := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.All,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: blocking()},
+					{Throttle: blocking()},
+				},
+			})
+			outer := dampener.NewThrottleGroup(dampener.ThrottleGroupOptions{
+				Mode: dampener.Any,
+				Rules: []dampener.ThrottleRule{
+					{Throttle: allowing()},
+					{Throttle: inner},
+				},
+			})
+			result, err := outer.ShouldThrottle(req)
+			So(err, ShouldBeNil)
+			So(result.ShouldThrottle, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener
+
+import "time"
+
+// Limiter - pluggable rate-limiting strategy used by dampenerThrottle.  Allow
+// reports whether cost units may be consumed for key at now, atomically
+// recording the consumption when allowed.  remaining is how many units are
+// left in the current window/bucket, and retryAfter is how long the caller
+// should wait before trying again when not allowed
+type Limiter interface {
+	Allow(key string, now time.Time, cost int) (allowed bool, remaining int64, retryAfter time.Duration, err error)
+}
+
+// fixedWindowLimiter - the original dampener strategy: counts discrete event
+// timestamps kept in Storage and throttles once more than maxCount of them
+// fall within the trailing window
+type fixedWindowLimiter struct {
+	storage  Storage
+	maxCount int
+	window   time.Duration
+}
+
+// NewFixedWindowLimiter - create a Limiter that counts discrete event
+// timestamps within a trailing window; this is the original dampener
+// behaviour, preserved as one of the selectable strategies
+func NewFixedWindowLimiter(storage Storage, maxCount int, window time.Duration) Limiter {
+	return &fixedWindowLimiter{storage: storage, maxCount: maxCount, window: window}
+}
+
+// Allow - implementation of Limiter for fixedWindowLimiter
+func (l *fixedWindowLimiter) Allow(key string, now time.Time, cost int) (bool, int64, time.Duration, error) {
+	count, err := l.storage.EventsInDuration(key, l.window)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	max := int64(l.maxCount)
+	if count > max {
+		return false, 0, l.window, nil
+	}
+	for i := 0; i < cost; i++ {
+		if err := l.storage.AppendEvent(key, now); err != nil {
+			return false, 0, 0, err
+		}
+	}
+	remaining := max - count - int64(cost)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return true, remaining, 0, nil
+}
+
+// gcraLimiter - a leaky-bucket limiter implementing the generic cell rate
+// algorithm (GCRA).  Each allowed request pushes a theoretical arrival time
+// (TAT) forward by the emission interval; a request is rejected once the TAT
+// runs further ahead of now than the configured burst allows
+type gcraLimiter struct {
+	storage          Storage
+	emissionInterval time.Duration
+	burst            int64
+}
+
+// NewGCRALimiter - create a Limiter implementing GCRA, permitting rate
+// events per period with the given burst allowance
+func NewGCRALimiter(storage Storage, rate int, period time.Duration, burst int) Limiter {
+	return &gcraLimiter{
+		storage:          storage,
+		emissionInterval: period / time.Duration(rate),
+		burst:            int64(burst),
+	}
+}
+
+// Allow - implementation of Limiter for gcraLimiter, following the standard
+// GCRA formula: TAT = max(now, prevTAT) + emissionInterval; reject if
+// TAT - now > burst*emissionInterval.  The whole check-and-advance is a
+// single call to AdvanceTAT so concurrent requests for the same key can't
+// interleave between a read and its commit
+func (l *gcraLimiter) Allow(key string, now time.Time, cost int) (bool, int64, time.Duration, error) {
+	increment := l.emissionInterval * time.Duration(cost)
+	burstWindow := l.emissionInterval * time.Duration(l.burst)
+	ttl := burstWindow + l.emissionInterval
+
+	tat, allowed, err := l.storage.AdvanceTAT(key, now, increment, burstWindow, ttl)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if !allowed {
+		retryAfter := tat.Add(increment).Sub(now) - burstWindow
+		return false, 0, retryAfter, nil
+	}
+	remaining := int64((burstWindow - tat.Sub(now)) / l.emissionInterval)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return true, remaining, 0, nil
+}
+
+// slidingWindowLimiter - a true sliding-window counter.  The previous
+// window's count is weighted by how much of it still overlaps the trailing
+// window, giving a smoother approximation than a fixed-window counter
+// without the storage cost of tracking every individual event
+type slidingWindowLimiter struct {
+	storage  Storage
+	maxCount int64
+	window   time.Duration
+}
+
+// NewSlidingWindowLimiter - create a Limiter that estimates the request rate
+// by weighting the previous window's count by its overlap with the current
+// one, rather than counting individual event timestamps
+func NewSlidingWindowLimiter(storage Storage, maxCount int, window time.Duration) Limiter {
+	return &slidingWindowLimiter{storage: storage, maxCount: int64(maxCount), window: window}
+}
+
+// Allow - implementation of Limiter for slidingWindowLimiter.  previousCount
+// is read on its own since the previous bucket is already closed and never
+// incremented again; the current bucket's check-against-the-estimate and its
+// increment are combined into a single IncrementWindowCountIfBelow call so
+// concurrent requests for the same key can't all pass the check before any
+// of them commits
+func (l *slidingWindowLimiter) Allow(key string, now time.Time, cost int) (bool, int64, time.Duration, error) {
+	currentStart := now.Truncate(l.window)
+	previousStart := currentStart.Add(-l.window)
+	elapsed := now.Sub(currentStart)
+
+	previousCount, err := l.storage.WindowCount(key, previousStart)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	overlap := float64(l.window-elapsed) / float64(l.window)
+	ceiling := float64(l.maxCount) - float64(previousCount)*overlap - float64(cost)
+
+	newCount, allowed, err := l.storage.IncrementWindowCountIfBelow(key, currentStart, int64(cost), l.window*2, ceiling)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if !allowed {
+		return false, 0, l.window - elapsed, nil
+	}
+	remaining := l.maxCount - int64(float64(newCount)+float64(previousCount)*overlap)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return true, remaining, 0, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+package dampener_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/husobee/dampener"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGCRALimiter(t *testing.T) {
+	Convey("Test NewGCRALimiter", t, func() {
+		storage := dampener.NewMemoryStorage()
+		// rate=10/s, burst=1: one request per 100ms, no slack
+		limiter := dampener.NewGCRALimiter(storage, 10, 1*time.Second, 1)
+		now := time.Now()
+
+		Convey("allows a request, then rejects one that arrives too soon", func() {
+			allowed, _, _, err := limiter.Allow("key", now, 1)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+
+			allowed, _, retryAfter, err := limiter.Allow("key", now, 1)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeFalse)
+			So(retryAfter, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("allows again once the emission interval has elapsed", func() {
+			allowed, _, _, err := limiter.Allow("key", now, 1)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+
+			allowed, _, _, err = limiter.Allow("key", now.Add(100*time.Millisecond), 1)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+		})
+
+		Convey("a burst of concurrent requests never exceeds the burst allowance", func() {
+			var allowedCount int64
+			var errCount int64
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					allowed, _, _, err := limiter.Allow("burst-key", now, 1)
+					if err != nil {
+						atomic.AddInt64(&errCount, 1)
+					}
+					if allowed {
+						atomic.AddInt64(&allowedCount, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(errCount, ShouldEqual, 0)
+			So(allowedCount, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	Convey("Test NewSlidingWindowLimiter", t, func() {
+		storage := dampener.NewMemoryStorage()
+		limiter := dampener.NewSlidingWindowLimiter(storage, 5, 1*time.Minute)
+		now := time.Now().Truncate(1 * time.Minute)
+
+		Convey("allows up to maxCount requests in a window, then rejects", func() {
+			for i := 0; i < 5; i++ {
+				allowed, _, _, err := limiter.Allow("key", now, 1)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldBeTrue)
+			}
+			allowed, _, retryAfter, err := limiter.Allow("key", now, 1)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeFalse)
+			So(retryAfter, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("a burst of concurrent requests never exceeds maxCount", func() {
+			var allowedCount int64
+			var errCount int64
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					allowed, _, _, err := limiter.Allow("burst-key", now, 1)
+					if err != nil {
+						atomic.AddInt64(&errCount, 1)
+					}
+					if allowed {
+						atomic.AddInt64(&allowedCount, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(errCount, ShouldEqual, 0)
+			So(allowedCount, ShouldEqual, 5)
+		})
+	})
+}
@@ -7,16 +7,23 @@ import (
 
 // MockStorage - Mockable implementation of the storage interface.  Use this in your unit tests
 // so that you can mock out the storage layer, like this:
-// storage := &MockStorage{
-//     MockSetKeyWithDuration: func(string, interface{}, time.Duration) error {
-//         return errors.New("mocking out a failure condition")
-//     },
-//}
+//
+//	storage := &MockStorage{
+//	    MockAppendEvent: func(string, time.Time) error {
+//	        return errors.New("mocking out a failure condition")
+//	    },
+//	}
+//
 // See this blog for rationale: https://husobee.github.io/golang/testing/unit-test/2015/06/08/golang-unit-testing.html
 type MockStorage struct {
-	MockEventsInDuration func(string, time.Duration) (int64, error)
-	MockAppendEvent      func(string, time.Time) error
-	MockClean            func(string, time.Time) error
+	MockEventsInDuration            func(string, time.Duration) (int64, error)
+	MockAppendEvent                 func(string, time.Time) error
+	MockClean                       func(string, time.Time) error
+	MockAdvanceTAT                  func(string, time.Time, time.Duration, time.Duration, time.Duration) (time.Time, bool, error)
+	MockWindowCount                 func(string, time.Time) (int64, error)
+	MockIncrementWindowCountIfBelow func(string, time.Time, int64, time.Duration, float64) (int64, bool, error)
+	MockAppendEventWithOutcome      func(string, time.Time, Outcome) error
+	MockFailuresInDuration          func(string, time.Duration) (int64, error)
 }
 
 // EventsInDuration - implementation of Storage interface, allowing for a custom mock
@@ -47,20 +54,71 @@ func (s *MockStorage) Clean(k string, t time.Time) error {
 	return nil
 }
 
+// AdvanceTAT - implementation of Storage interface, allowing for a custom
+// mock function to be specified for unit testing
+func (s *MockStorage) AdvanceTAT(k string, now time.Time, increment, burstWindow, ttl time.Duration) (time.Time, bool, error) {
+	if s.MockAdvanceTAT != nil {
+		return s.MockAdvanceTAT(k, now, increment, burstWindow, ttl)
+	}
+	return time.Time{}, true, nil
+}
+
+// AppendEventWithOutcome - implementation of Storage interface, allowing for
+// a custom mock function to be specified for unit testing
+func (s *MockStorage) AppendEventWithOutcome(k string, t time.Time, outcome Outcome) error {
+	if s.MockAppendEventWithOutcome != nil {
+		return s.MockAppendEventWithOutcome(k, t, outcome)
+	}
+	return nil
+}
+
+// FailuresInDuration - implementation of Storage interface, allowing for a
+// custom mock function to be specified for unit testing
+func (s *MockStorage) FailuresInDuration(k string, d time.Duration) (int64, error) {
+	if s.MockFailuresInDuration != nil {
+		return s.MockFailuresInDuration(k, d)
+	}
+	return 0, nil
+}
+
+// WindowCount - implementation of Storage interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockStorage) WindowCount(k string, windowStart time.Time) (int64, error) {
+	if s.MockWindowCount != nil {
+		return s.MockWindowCount(k, windowStart)
+	}
+	return 0, nil
+}
+
+// IncrementWindowCountIfBelow - implementation of Storage interface,
+// allowing for a custom mock function to be specified for unit testing
+func (s *MockStorage) IncrementWindowCountIfBelow(k string, windowStart time.Time, by int64, ttl time.Duration, ceiling float64) (int64, bool, error) {
+	if s.MockIncrementWindowCountIfBelow != nil {
+		return s.MockIncrementWindowCountIfBelow(k, windowStart, by, ttl, ceiling)
+	}
+	return 0, true, nil
+}
+
 // MockThrottleOptions - Mockable implementation of the throttle options interface.  Use this in your unit tests
 // so that you can mock out the throttle options layer, like this:
-// throttleOptions := &MockThrottleOptions{
-//     MockGetStorage: func() Storage{
-//	       return &MockStorage{}
-//     },
-//}
+//
+//	throttleOptions := &MockThrottleOptions{
+//	    MockGetStorage: func() Storage{
+//		       return &MockStorage{}
+//	    },
+//	}
+//
 // See this blog for rationale: https://husobee.github.io/golang/testing/unit-test/2015/06/08/golang-unit-testing.html
 type MockThrottleOptions struct {
-	MockGetPrefix     func() string
-	MockGetDuration   func() time.Duration
-	MockMatchCriteria func() func(*http.Request) bool
-	MockGetStorage    func() Storage
-	MockGetMaxCount   func() int
+	MockGetPrefix       func() string
+	MockGetDuration     func() time.Duration
+	MockMatchCriteria   func() func(*http.Request) bool
+	MockGetStorage      func() Storage
+	MockGetMaxCount     func() int
+	MockGetLimiter      func() Limiter
+	MockCleanupInterval func() time.Duration
+	MockLogger          func() Logger
+	MockMetrics         func() Metrics
 }
 
 // GetPrefix - implementation of ThrottleOptions interface, allowing for a custom mock
@@ -108,13 +166,51 @@ func (s *MockThrottleOptions) GetStorage() Storage {
 	return &MockStorage{}
 }
 
+// GetLimiter - implementation of ThrottleOptions interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockThrottleOptions) GetLimiter() Limiter {
+	if s.MockGetLimiter != nil {
+		return s.MockGetLimiter()
+	}
+	return NewFixedWindowLimiter(s.GetStorage(), s.GetMaxCount(), s.GetDuration())
+}
+
+// CleanupInterval - implementation of ThrottleOptions interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockThrottleOptions) CleanupInterval() time.Duration {
+	if s.MockCleanupInterval != nil {
+		return s.MockCleanupInterval()
+	}
+	return 1 * time.Minute
+}
+
+// Logger - implementation of ThrottleOptions interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockThrottleOptions) Logger() Logger {
+	if s.MockLogger != nil {
+		return s.MockLogger()
+	}
+	return DefaultLogger
+}
+
+// Metrics - implementation of ThrottleOptions interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockThrottleOptions) Metrics() Metrics {
+	if s.MockMetrics != nil {
+		return s.MockMetrics()
+	}
+	return noopMetrics{}
+}
+
 // MockThrottler - Mockable implementation of the throttler interface.  Use this in your unit tests
 // so that you can mock out the throttle options layer, like this:
-// throttler := &MockThrottler{
-//     MockGetThrottles: func() []Throttle{
-//	       return []Throttles{&MockThrottle{}}
-//     },
-//}
+//
+//	throttler := &MockThrottler{
+//	    MockGetThrottles: func() []Throttle{
+//		       return []Throttle{&MockThrottle{}}
+//	    },
+//	}
+//
 // See this blog for rationale: https://husobee.github.io/golang/testing/unit-test/2015/06/08/golang-unit-testing.html
 type MockThrottler struct {
 	MockServeHTTP    func(http.ResponseWriter, *http.Request)
@@ -152,11 +248,13 @@ func (s *MockThrottler) GetOptions() ThrottlerOptions {
 
 // MockThrottlerOptions - Mockable implementation of the throttleroptions interface.  Use this in your unit tests
 // so that you can mock out the throttle options layer, like this:
-// throttlerOptions := &MockThrottlerOptions{
-//     MockGetStatus: func() int {
-//         return http.StatusOK
-//     },
-//}
+//
+//	throttlerOptions := &MockThrottlerOptions{
+//	    MockGetStatus: func() int {
+//	        return http.StatusOK
+//	    },
+//	}
+//
 // See this blog for rationale: https://husobee.github.io/golang/testing/unit-test/2015/06/08/golang-unit-testing.html
 type MockThrottlerOptions struct {
 	MockGetStatus  func() int
@@ -183,16 +281,20 @@ func (s *MockThrottlerOptions) GetMessage() []byte {
 
 // MockThrottle - Mockable implementation of the throttle interface.  Use this in your unit tests
 // so that you can mock out the throttle options layer, like this:
-// throttle := &MockThrottle{
-//     MockShouldThrottle: func(*http.Request) bool{
-//         return true
-//     },
-//}
+//
+//	throttle := &MockThrottle{
+//	    MockShouldThrottle: func(*http.Request) bool{
+//	        return true
+//	    },
+//	}
+//
 // See this blog for rationale: https://husobee.github.io/golang/testing/unit-test/2015/06/08/golang-unit-testing.html
 type MockThrottle struct {
-	MockGetOptions     func() ThrottleOptions
-	MockShouldThrottle func(*http.Request) bool
-	MockAppendEvent    func(*http.Request)
+	MockGetOptions           func() ThrottleOptions
+	MockShouldThrottle       func(*http.Request) bool
+	MockShouldThrottleResult func(*http.Request) (ThrottleCheckResult, error)
+	MockAppendEvent          func(*http.Request)
+	MockStop                 func()
 }
 
 // GetOptions - implementation of Throttle interface, allowing for a custom mock
@@ -205,12 +307,17 @@ func (s *MockThrottle) GetOptions() ThrottleOptions {
 }
 
 // ShouldThrottle - implementation of Throttle interface, allowing for a custom mock
-// function to be specified for unit testing
-func (s *MockThrottle) ShouldThrottle(r *http.Request) bool {
+// function to be specified for unit testing.  MockShouldThrottleResult takes
+// precedence; MockShouldThrottle is kept as a thin adapter for tests that have
+// not yet migrated to the richer ThrottleCheckResult API
+func (s *MockThrottle) ShouldThrottle(r *http.Request) (ThrottleCheckResult, error) {
+	if s.MockShouldThrottleResult != nil {
+		return s.MockShouldThrottleResult(r)
+	}
 	if s.MockShouldThrottle != nil {
-		return s.MockShouldThrottle(r)
+		return ThrottleCheckResult{ShouldThrottle: s.MockShouldThrottle(r)}, nil
 	}
-	return false
+	return ThrottleCheckResult{}, nil
 }
 
 // AppendEvent - implementation of Throttle interface, allowing for a custom mock
@@ -222,3 +329,11 @@ func (s *MockThrottle) AppendEvent(r *http.Request) {
 	}
 	return
 }
+
+// Stop - implementation of Throttle interface, allowing for a custom mock
+// function to be specified for unit testing
+func (s *MockThrottle) Stop() {
+	if s.MockStop != nil {
+		s.MockStop()
+	}
+}
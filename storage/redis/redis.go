@@ -0,0 +1,228 @@
+// Copyright 2015 - husobee associates, llc; all rights reserved.
+
+// Package redis - A Storage implementation of github.com/husobee/dampener
+// backed by Redis, suitable for throttling across multiple processes
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/husobee/dampener"
+)
+
+// appendEventScript - atomically records a new event timestamp in the
+// sorted set for a key and refreshes its TTL, so a key with no further
+// traffic is eventually reclaimed by Redis.  Members are suffixed with a
+// per-key sequence number so that two events recorded in the same
+// nanosecond don't collide and get deduplicated by ZADD
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1] .. ':seq')
+redis.call('PEXPIRE', KEYS[1] .. ':seq', ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2] .. ':' .. seq)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return redis.status_reply('OK')
+`)
+
+// eventsInDurationScript - atomically evicts events older than the window
+// and returns the remaining count, so EventsInDuration never has to race
+// against a separate Clean call
+var eventsInDurationScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// cleanScript - evicts events recorded before the given score
+var cleanScript = redis.NewScript(`
+return redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+`)
+
+// advanceTATScript - atomically performs one step of the GCRA algorithm:
+// reads the stored theoretical arrival time, advances it to at least now,
+// adds the emission increment, and commits the result only if it stays
+// within the burst window of now.  Doing the check and the write in one
+// script is what makes this safe for concurrent callers on the same key,
+// unlike a separate read-then-write round trip.  All timestamps are passed
+// in microseconds rather than Go's native nanoseconds: Lua numbers are
+// float64, which can only represent integers exactly up to 2^53, and a
+// nanosecond Unix timestamp already exceeds that
+var advanceTATScript = redis.NewScript(`
+local prev = tonumber(redis.call('GET', KEYS[1]))
+local now = tonumber(ARGV[1])
+local increment = tonumber(ARGV[2])
+local burstWindow = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local tat = now
+if prev and prev > now then
+  tat = prev
+end
+local candidate = tat + increment
+if candidate - now > burstWindow then
+  return {tat, 0}
+end
+redis.call('SET', KEYS[1], candidate, 'PX', ttl)
+return {candidate, 1}
+`)
+
+// incrementWindowIfBelowScript - atomically checks the sliding-window
+// bucket counter against ceiling and only increments it when at or below,
+// refreshing its TTL when it does.  The check and the increment happen in
+// one script so concurrent callers can't all pass the check before any of
+// them commits
+var incrementWindowIfBelowScript = redis.NewScript(`
+local n = tonumber(redis.call('GET', KEYS[1]) or '0')
+local ceiling = tonumber(ARGV[2])
+if n > ceiling then
+  return {n, 0}
+end
+n = n + tonumber(ARGV[1])
+redis.call('SET', KEYS[1], n, 'PX', ARGV[3])
+return {n, 1}
+`)
+
+// failuresInDurationScript - atomically evicts failures older than the
+// window and returns the remaining count, mirroring eventsInDurationScript
+var failuresInDurationScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+return redis.call('ZCARD', KEYS[1])
+`)
+
+// RedisStorage - implementation of dampener.Storage backed by Redis.  Keys
+// are namespaced under a configurable prefix and hashtagged per throttle
+// prefix so that all the keys a single throttle touches land on the same
+// Redis Cluster shard, keeping the Lua scripts above atomic in a cluster
+type RedisStorage struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedisStorage - create a new RedisStorage on top of an injected
+// redis.UniversalClient (works for a single node, a sentinel-backed
+// failover client, or a cluster client).  keyPrefix namespaces every key
+// this Storage writes; ttl bounds how long idle keys live in Redis
+func NewRedisStorage(client redis.UniversalClient, keyPrefix string, ttl time.Duration) dampener.Storage {
+	return &RedisStorage{
+		client:    client,
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+	}
+}
+
+// key - build a cluster-safe key for prefix, hashtagging the variable part
+// so every key derived from the same throttle prefix maps to the same shard
+func (r *RedisStorage) key(prefix string, suffix string) string {
+	if suffix == "" {
+		return fmt.Sprintf("%s:{%s}", r.keyPrefix, prefix)
+	}
+	return fmt.Sprintf("%s:{%s}:%s", r.keyPrefix, prefix, suffix)
+}
+
+// AppendEvent - implementing dampener.Storage, adding a new event timestamp
+// to the sorted set for prefix
+func (r *RedisStorage) AppendEvent(prefix string, t time.Time) error {
+	ctx := context.Background()
+	return appendEventScript.Run(ctx, r.client, []string{r.key(prefix, "")},
+		t.UnixNano(), t.UnixNano(), r.ttl.Milliseconds()).Err()
+}
+
+// EventsInDuration - implementing dampener.Storage, evicting events older
+// than d and returning how many remain for prefix
+func (r *RedisStorage) EventsInDuration(prefix string, d time.Duration) (int64, error) {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-1 * d).UnixNano()
+	return eventsInDurationScript.Run(ctx, r.client, []string{r.key(prefix, "")}, cutoff).Int64()
+}
+
+// Clean - implementing dampener.Storage, evicting events recorded before to
+func (r *RedisStorage) Clean(prefix string, to time.Time) error {
+	ctx := context.Background()
+	return cleanScript.Run(ctx, r.client, []string{r.key(prefix, "")}, to.UnixNano()).Err()
+}
+
+// AdvanceTAT - implementing dampener.Storage, atomically advancing the
+// stored GCRA theoretical arrival time for prefix.  Times cross into Lua at
+// microsecond resolution (see advanceTATScript), so the TAT returned here is
+// only accurate to the microsecond
+func (r *RedisStorage) AdvanceTAT(prefix string, now time.Time, increment, burstWindow, ttl time.Duration) (time.Time, bool, error) {
+	ctx := context.Background()
+	res, err := advanceTATScript.Run(ctx, r.client, []string{r.key(prefix, "tat")},
+		now.UnixMicro(), increment.Microseconds(), burstWindow.Microseconds(), ttl.Milliseconds()).Result()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return time.Time{}, false, fmt.Errorf("redis: unexpected AdvanceTAT reply: %#v", res)
+	}
+	micros, err := strconv.ParseInt(fmt.Sprint(vals[0]), 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	allowed, err := strconv.ParseInt(fmt.Sprint(vals[1]), 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMicro(micros), allowed == 1, nil
+}
+
+// WindowCount - implementing dampener.Storage, reading the sliding-window
+// bucket count for prefix at windowStart without modifying it
+func (r *RedisStorage) WindowCount(prefix string, windowStart time.Time) (int64, error) {
+	ctx := context.Background()
+	key := r.key(prefix, "win:"+strconv.FormatInt(windowStart.UnixNano(), 10))
+	count, err := r.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// IncrementWindowCountIfBelow - implementing dampener.Storage, atomically
+// checking and incrementing the sliding-window bucket count for prefix at
+// windowStart
+func (r *RedisStorage) IncrementWindowCountIfBelow(prefix string, windowStart time.Time, by int64, ttl time.Duration, ceiling float64) (int64, bool, error) {
+	ctx := context.Background()
+	key := r.key(prefix, "win:"+strconv.FormatInt(windowStart.UnixNano(), 10))
+	res, err := incrementWindowIfBelowScript.Run(ctx, r.client, []string{key}, by, ceiling, ttl.Milliseconds()).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, false, fmt.Errorf("redis: unexpected IncrementWindowCountIfBelow reply: %#v", res)
+	}
+	count, err := strconv.ParseInt(fmt.Sprint(vals[0]), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	allowed, err := strconv.ParseInt(fmt.Sprint(vals[1]), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return count, allowed == 1, nil
+}
+
+// AppendEventWithOutcome - implementing dampener.Storage.  Only Failure
+// outcomes are retained, in a sorted set separate from the plain AppendEvent
+// one, so BruteforceThrottle's failure count never mixes with another
+// Limiter's bookkeeping for the same prefix
+func (r *RedisStorage) AppendEventWithOutcome(prefix string, t time.Time, outcome dampener.Outcome) error {
+	if outcome != dampener.Failure {
+		return nil
+	}
+	ctx := context.Background()
+	return appendEventScript.Run(ctx, r.client, []string{r.key(prefix, "fail")},
+		t.UnixNano(), t.UnixNano(), r.ttl.Milliseconds()).Err()
+}
+
+// FailuresInDuration - implementing dampener.Storage, evicting failures
+// older than d and returning how many remain for prefix
+func (r *RedisStorage) FailuresInDuration(prefix string, d time.Duration) (int64, error) {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-1 * d).UnixNano()
+	return failuresInDurationScript.Run(ctx, r.client, []string{r.key(prefix, "fail")}, cutoff).Int64()
+}
@@ -0,0 +1,39 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/husobee/dampener"
+	"github.com/husobee/dampener/storage/redis"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// BenchmarkMemoryStorageAppendEvent - baseline: in-process, no network hop
+func BenchmarkMemoryStorageAppendEvent(b *testing.B) {
+	storage := dampener.NewMemoryStorage()
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		storage.AppendEvent("key", now)
+	}
+}
+
+// BenchmarkRedisStorageAppendEvent - same workload against a RedisStorage
+// backed by miniredis, to see the cost of the round trip and the Lua script
+func BenchmarkRedisStorageAppendEvent(b *testing.B) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		b.Fatalf("failed to start miniredis: %s", err)
+	}
+	defer mr.Close()
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	storage := redis.NewRedisStorage(client, "dampener-bench", 1*time.Minute)
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		storage.AppendEvent("key", now)
+	}
+}
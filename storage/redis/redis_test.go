@@ -0,0 +1,112 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/husobee/dampener"
+	"github.com/husobee/dampener/storage/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestStorage - spin up a miniredis instance and a RedisStorage pointed
+// at it, returning a cleanup func to shut the server down
+func newTestStorage(t *testing.T) (dampener.Storage, func()) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %s", err)
+	}
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	storage := redis.NewRedisStorage(client, "dampener-test", 1*time.Minute)
+	return storage, func() {
+		client.Close()
+		mr.Close()
+	}
+}
+
+func TestRedisStorageEvents(t *testing.T) {
+	Convey("Test RedisStorage event counting", t, func() {
+		storage, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		Convey("counts events recorded within the window", func() {
+			now := time.Now()
+			for i := 0; i < 5; i++ {
+				So(storage.AppendEvent("key", now), ShouldBeNil)
+			}
+			count, err := storage.EventsInDuration("key", 1*time.Minute)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 5)
+		})
+
+		Convey("Clean evicts events before the given time", func() {
+			now := time.Now()
+			So(storage.AppendEvent("key", now.Add(-1*time.Hour)), ShouldBeNil)
+			So(storage.AppendEvent("key", now), ShouldBeNil)
+			So(storage.Clean("key", now.Add(-1*time.Minute)), ShouldBeNil)
+			count, err := storage.EventsInDuration("key", 1*time.Hour)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRedisStorageGCRA(t *testing.T) {
+	Convey("Test RedisStorage AdvanceTAT", t, func() {
+		storage, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		Convey("advances the TAT when within the burst window, holds it when not", func() {
+			// truncated to microsecond precision: that's what survives the
+			// round trip through the Lua script, see advanceTATScript
+			now := time.Now().Truncate(time.Microsecond)
+			tat, allowed, err := storage.AdvanceTAT("key", now, 1*time.Second, 1*time.Second, 1*time.Minute)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+			So(tat.UnixMicro(), ShouldEqual, now.Add(1*time.Second).UnixMicro())
+
+			// a second request right away would push the TAT 2s ahead of
+			// now, past the 1s burst allowance, so it is rejected and the
+			// stored TAT is left untouched
+			tat, allowed, err = storage.AdvanceTAT("key", now, 1*time.Second, 1*time.Second, 1*time.Minute)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeFalse)
+			So(tat.UnixMicro(), ShouldEqual, now.Add(1*time.Second).UnixMicro())
+		})
+	})
+}
+
+func TestRedisStorageSlidingWindow(t *testing.T) {
+	Convey("Test RedisStorage sliding-window counters", t, func() {
+		storage, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		Convey("increments and reads back the bucket count", func() {
+			windowStart := time.Now().Truncate(time.Minute)
+			count, allowed, err := storage.IncrementWindowCountIfBelow("key", windowStart, 3, 1*time.Minute, 10)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+			So(count, ShouldEqual, 3)
+
+			read, err := storage.WindowCount("key", windowStart)
+			So(err, ShouldBeNil)
+			So(read, ShouldEqual, 3)
+		})
+
+		Convey("refuses to increment past the ceiling", func() {
+			windowStart := time.Now().Truncate(time.Minute)
+			count, allowed, err := storage.IncrementWindowCountIfBelow("key", windowStart, 8, 1*time.Minute, 5)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeTrue)
+			So(count, ShouldEqual, 8)
+
+			count, allowed, err = storage.IncrementWindowCountIfBelow("key", windowStart, 1, 1*time.Minute, 5)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldBeFalse)
+			So(count, ShouldEqual, 8)
+		})
+	})
+}